@@ -8,6 +8,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -92,6 +93,398 @@ func TestDatabase_Presistence(t *testing.T) {
 	}
 }
 
+func TestDatabase_MaxStatementLen(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "dbtest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir) // cleanup
+
+	buff := new(bytes.Buffer)
+	in := bytes.NewBuffer([]byte("insert 1 ab\n" + strings.Repeat("a", 100) + "\nselect\n.exit"))
+	args := []string{os.Args[0], filepath.Join(dir, "test.db")}
+	code := db.MainWithConfig(db.Config{MaxStatementLen: 10}, buff, buff, in, args)
+	if code != 0 {
+		t.Errorf("exit code, expected 0 got %d", code)
+		return
+	}
+	if !CheckOutputStrings(
+		"db > Error: Statement too long.",
+		"db > Error: Statement too long.",
+		"db > Executed.",
+		"db > ",
+	).Check(t, buff.Bytes()) {
+		return
+	}
+}
+
+func TestDatabase_PresistenceMultiplePages(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "dbtest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir) // cleanup
+
+	numRows := int(db.RowsPerPage) + 3
+	buff := new(bytes.Buffer)
+	for i := 1; i <= numRows; i++ {
+		fmt.Fprintf(buff, "insert %[1]d user%[1]d person%[1]d@example.com\n", i)
+	}
+	buff.WriteString(".exit")
+	in := bytes.NewBuffer(buff.Bytes())
+	buff = new(bytes.Buffer)
+	args := []string{os.Args[0], filepath.Join(dir, "test.db")}
+	if code := db.Main(buff, buff, in, args); code != 0 {
+		t.Errorf("exit code, expected 0 got %d", code)
+		return
+	}
+
+	// the rows must all be found when the database is reopened, and new
+	// rows must not overwrite them.
+	buff.Reset()
+	in = bytes.NewBuffer([]byte(fmt.Sprintf("insert %[1]d user%[1]d person%[1]d@example.com\nselect\n.exit", numRows+1)))
+	if code := db.Main(buff, buff, in, args); code != 0 {
+		t.Errorf("exit code, expected 0 got %d", code)
+		return
+	}
+	strs := []string{"db > Executed."}
+	for i := 1; i <= numRows+1; i++ {
+		str := fmt.Sprintf("(%[1]d, user%[1]d, person%[1]d@example.com)", i)
+		if i == 1 {
+			str = "db > " + str
+		}
+		strs = append(strs, str)
+	}
+	strs = append(strs, "Executed.", "db > ")
+	if !CheckOutputStrings(strs...).Check(t, buff.Bytes()) {
+		return
+	}
+}
+
+func TestDatabase_Recover(t *testing.T) {
+	type tcase struct {
+		// setup writes out the database file to recover
+		setup  func(t *testing.T, filename string)
+		check  CheckFn
+		length int64
+	}
+
+	dir, err := ioutil.TempDir("", "dbtest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir) // cleanup
+
+	rowBytes := func(ids ...uint32) []byte {
+		var b []byte
+		for _, id := range ids {
+			row := db.Row{ID: id}
+			copy(row.Username[:], fmt.Sprintf("user%d", id-1))
+			copy(row.Email[:], fmt.Sprintf("person%d@example.com", id-1))
+			rowByte := row.Seralize()
+			b = append(b, rowByte[:]...)
+		}
+		return b
+	}
+	writeFile := func(t *testing.T, filename string, b []byte, offset int64) {
+		t.Helper()
+		f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatalf("open, expected nil got %v", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteAt(b, offset); err != nil {
+			t.Fatalf("write, expected nil got %v", err)
+		}
+	}
+
+	fn := func(tc tcase) func(*testing.T) {
+		return func(t *testing.T) {
+			filename := filepath.Join(dir, filepath.Base(t.Name())+".db")
+			tc.setup(t, filename)
+
+			buff := new(bytes.Buffer)
+			in := bytes.NewBuffer([]byte(".recover\nselect\n.exit"))
+			if code := db.Main(buff, buff, in, []string{os.Args[0], filename}); code != 0 {
+				t.Errorf("exit code, expected 0 got %d", code)
+				return
+			}
+			if !tc.check(t, buff.Bytes()) {
+				return
+			}
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatalf("stat, expected nil got %v", err)
+			}
+			if info.Size() != tc.length {
+				t.Errorf("size, expected %d got %d", tc.length, info.Size())
+			}
+		}
+	}
+
+	tests := map[string]tcase{
+		"partially written second page": {
+			setup: func(t *testing.T, filename string) {
+				buff := new(bytes.Buffer)
+				in := bytes.NewBuffer([]byte("insert 1 user1 person1@example.com\n.exit"))
+				if code := db.Main(buff, buff, in, []string{os.Args[0], filename}); code != 0 {
+					t.Fatalf("exit code, expected 0 got %d", code)
+				}
+				// simulate a crash part way through writing out a second page.
+				writeFile(t, filename, append(rowBytes(3, 4), 1, 2, 3), db.PageSize)
+			},
+			check: CheckOutputStrings(
+				"db > Recovered database, 2 rows lost.",
+				"db > (1, user1, person1@example.com)",
+				"Executed.",
+				"db > ",
+			).Check,
+			length: db.PageSize,
+		},
+		"partially written first page": {
+			setup: func(t *testing.T, filename string) {
+				// one whole row, and the start of a second.
+				writeFile(t, filename, append(rowBytes(2), 1, 2), 0)
+			},
+			check: CheckOutputStrings(
+				"db > Recovered database, 1 rows lost.",
+				"db > Executed.",
+				"db > ",
+			).Check,
+			length: 0,
+		},
+		"page written before checksums": {
+			setup: func(t *testing.T, filename string) {
+				page := make([]byte, db.PageSize)
+				copy(page, rowBytes(2, 3, 4))
+				writeFile(t, filename, page, 0)
+			},
+			check: CheckOutputStrings(
+				"db > Recovered database, 0 rows lost.",
+				"db > (1, user1, person1@example.com)",
+				"(2, user2, person2@example.com)",
+				"(3, user3, person3@example.com)",
+				"Executed.",
+				"db > ",
+			).Check,
+			length: db.PageSize,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, fn(tc))
+	}
+}
+
+func TestDatabase_Timer(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "dbtest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir) // cleanup
+
+	buff := new(bytes.Buffer)
+	in := bytes.NewBuffer([]byte("insert 1 user1 person1@example.com\n.timer on\nselect\n.timer off\nselect\n.exit"))
+	args := []string{os.Args[0], filepath.Join(dir, "test.db")}
+	code := db.Main(buff, buff, in, args)
+	if code != 0 {
+		t.Errorf("exit code, expected 0 got %d", code)
+		return
+	}
+	lines := strings.Split(buff.String(), "\n")
+	timed := regexp.MustCompile(`^Run Time: Real: [0-9]+\.[0-9]+$`)
+	var count int
+	for _, l := range lines {
+		if timed.MatchString(l) {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("timed lines, expected 1 got %d", count)
+		t.Logf("output:\n%s", buff.String())
+		return
+	}
+	// the time should come after the result of the select.
+	for i, l := range lines {
+		if timed.MatchString(l) && lines[i-1] != "Executed." {
+			t.Errorf("line before time, expected 'Executed.' got '%s'", lines[i-1])
+		}
+	}
+}
+
+func TestDatabase_Union(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "dbtest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir) // cleanup
+
+	buff := new(bytes.Buffer)
+	in := bytes.NewBuffer([]byte(`insert 3 user3 person3@example.com
+insert 4 user4 person4@example.com
+insert 1 user1 person1@example.com
+.exit`))
+	code := db.Main(buff, buff, in, []string{os.Args[0], filepath.Join(dir, "other.db")})
+	if code != 0 {
+		t.Errorf("exit code, expected 0 got %d", code)
+		return
+	}
+
+	buff.Reset()
+	in = bytes.NewBuffer([]byte(`insert 1 user1 person1@example.com
+insert 2 user2 person2@example.com
+insert 3 user3 person3@example.com
+select union all select from other.db
+select union select from other.db
+select id union select id from other.db
+select union select union all select
+select union all select union select
+.exit`))
+	code = db.Main(buff, buff, in, []string{os.Args[0], filepath.Join(dir, "test.db")})
+	if code != 0 {
+		t.Errorf("exit code, expected 0 got %d", code)
+		return
+	}
+	if !CheckOutputStrings(
+		"db > Executed.",
+		"db > Executed.",
+		"db > Executed.",
+		// union all
+		"db > (1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"(3, user3, person3@example.com)",
+		"(3, user3, person3@example.com)",
+		"(4, user4, person4@example.com)",
+		"(1, user1, person1@example.com)",
+		"Executed.",
+		// union
+		"db > (1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"(3, user3, person3@example.com)",
+		"(4, user4, person4@example.com)",
+		"Executed.",
+		// union of projected columns
+		"db > (1)",
+		"(2)",
+		"(3)",
+		"(4)",
+		"Executed.",
+		// the union all after a union keeps its duplicates
+		"db > (1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"(3, user3, person3@example.com)",
+		"(1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"(3, user3, person3@example.com)",
+		"Executed.",
+		// the union after a union all removes every duplicate
+		"db > (1, user1, person1@example.com)",
+		"(2, user2, person2@example.com)",
+		"(3, user3, person3@example.com)",
+		"Executed.",
+		"db > ",
+	).Check(t, buff.Bytes()) {
+		return
+	}
+}
+
+func TestDatabase_SelectFrom(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "dbtest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir) // cleanup
+
+	buff := new(bytes.Buffer)
+	in := bytes.NewBuffer([]byte("insert 3 user3 person3@example.com\n.exit"))
+	if code := db.Main(buff, buff, in, []string{os.Args[0], filepath.Join(dir, "other.db")}); code != 0 {
+		t.Errorf("exit code, expected 0 got %d", code)
+		return
+	}
+	files := map[string][]byte{
+		"notes.txt": []byte("hello world\n"),
+		"short.db":  []byte("hello world\n"),
+		"junk.db":   bytes.Repeat([]byte("not a database\n"), db.PageSize/15+1)[:db.PageSize],
+		// a page written before checksums, which would gain one if it
+		// were written back.
+		"old.db": func() []byte {
+			row := db.Row{ID: 5}
+			copy(row.Username[:], "user4")
+			copy(row.Email[:], "person4@example.com")
+			rowByte := row.Seralize()
+			page := make([]byte, db.PageSize)
+			copy(page, rowByte[:])
+			return page
+		}(),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatalf("write %s, expected nil got %v", name, err)
+		}
+	}
+	other, err := ioutil.ReadFile(filepath.Join(dir, "other.db"))
+	if err != nil {
+		t.Fatalf("read, expected nil got %v", err)
+	}
+	files["other.db"] = other
+
+	buff.Reset()
+	in = bytes.NewBuffer([]byte(`select from other.db
+select from other
+select from old.db
+select from notes.txt
+select from short.db
+select from junk.db
+select from ../other.db
+select from missing.db
+select from other.db union all select from missing.db
+.exit`))
+	code := db.Main(buff, buff, in, []string{os.Args[0], filepath.Join(dir, "test.db")})
+	if code != 0 {
+		t.Errorf("exit code, expected 0 got %d", code)
+		return
+	}
+	outputs := strings.Split(buff.String(), "db > ")
+	if len(outputs) != 11 {
+		t.Fatalf("output, expected 11 prompts got %d:\n%s", len(outputs), buff.String())
+	}
+	expected := []string{
+		"(3, user3, person3@example.com)\nExecuted.\n",
+		"(3, user3, person3@example.com)\nExecuted.\n",
+		"(4, user4, person4@example.com)\nExecuted.\n",
+		"failed to open table notes.txt, must name a .db file",
+		"failed to open table short.db, file is not a database",
+		"failed to open table junk.db, file is not a database",
+		"failed to open table ../other.db, must name a .db file",
+		"failed to open table missing.db, open " + filepath.Join(dir, "missing.db"),
+		// no rows are written out before the error.
+		"failed to open table missing.db, open " + filepath.Join(dir, "missing.db"),
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(outputs[i+1], prefix) {
+			t.Errorf("statement %d, expected output to start with `%s` got `%s`", i, prefix, outputs[i+1])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing.db")); !os.IsNotExist(err) {
+		t.Errorf("missing.db, expected file not to be created")
+	}
+
+	// selecting must never write to another file.
+	for name, content := range files {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s, expected nil got %v", name, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%s, expected file to be unchanged", name)
+		}
+	}
+}
+
 func TestDatabase(t *testing.T) {
 	type tcase struct {
 		inputs []byte
@@ -181,6 +574,52 @@ db > `)).Check,
 			code:   0,
 			check: checkOutput([]byte(`db > ID must be positive.
 db > Executed.
+db > `)).Check,
+		},
+		"insert returning prints the inserted row": {
+			inputs: []byte("insert 1 alice a@b.com returning *\nselect\n.exit"),
+			code:   0,
+			check: checkOutput([]byte(`db > (1, alice, a@b.com)
+Executed.
+db > (1, alice, a@b.com)
+Executed.
+db > `)).Check,
+		},
+		"insert returning requires a star": {
+			inputs: []byte("insert 1 alice a@b.com returning id\nselect\n.exit"),
+			code:   0,
+			check: checkOutput([]byte(`db > Syntax error. Could not parse statement.
+db > Executed.
+db > `)).Check,
+		},
+		"select returns only the named columns": {
+			inputs: []byte("insert 1 user1 person1@example.com\nselect id, username\nselect email,id\nselect *\n.exit"),
+			code:   0,
+			check: checkOutput([]byte(`db > Executed.
+db > (1, user1)
+Executed.
+db > (person1@example.com, 1)
+Executed.
+db > (1, user1, person1@example.com)
+Executed.
+db > `)).Check,
+		},
+		"print an error message for an unknown column": {
+			inputs: []byte("select id, name\n.exit"),
+			code:   0,
+			check: checkOutput([]byte(`db > Syntax error. Could not parse statement.
+db > `)).Check,
+		},
+		"print an error message if union selects differ in columns": {
+			inputs: []byte("select id union all select\n.exit"),
+			code:   0,
+			check: checkOutput([]byte(`db > Syntax error. Could not parse statement.
+db > `)).Check,
+		},
+		"print an error message for an unknown savepoint": {
+			inputs: []byte("rollback to sp1\n.exit"),
+			code:   0,
+			check: checkOutput([]byte(`db > Error: No such savepoint: sp1.
 db > `)).Check,
 		},
 	}
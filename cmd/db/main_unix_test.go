@@ -0,0 +1,78 @@
+//go:build unix
+
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gdey/db_tutorial/db"
+)
+
+func TestDatabase_Locked(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "dbtest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir) // cleanup
+
+	args := []string{os.Args[0], filepath.Join(dir, "test.db")}
+	pager, err := db.NewPager(args[1])
+	if err != nil {
+		t.Fatalf("pager, expected nil got %v", err)
+	}
+	defer pager.Close()
+
+	buff := new(bytes.Buffer)
+	in := bytes.NewBuffer([]byte("select\n.exit"))
+	code := db.MainWithConfig(db.Config{LockTimeout: 50 * time.Millisecond}, buff, buff, in, args)
+	if code != 2 {
+		t.Errorf("exit code, expected 2 got %d", code)
+	}
+	if !CheckOutputStrings("Database file("+args[1]+") is locked by another process.\n").Check(t, buff.Bytes()) {
+		return
+	}
+}
+
+func TestDatabase_SelectFromLocked(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "dbtest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir) // cleanup
+
+	args := []string{os.Args[0], filepath.Join(dir, "test.db")}
+	// a link to our own database file is locked by us.
+	if err := os.Symlink(args[1], filepath.Join(dir, "self.db")); err != nil {
+		t.Fatalf("symlink, expected nil got %v", err)
+	}
+	pager, err := db.NewPager(filepath.Join(dir, "other.db"))
+	if err != nil {
+		t.Fatalf("pager, expected nil got %v", err)
+	}
+	defer pager.Close()
+
+	buff := new(bytes.Buffer)
+	in := bytes.NewBuffer([]byte("select from self.db\nselect from other.db\n.exit"))
+	start := time.Now()
+	code := db.MainWithConfig(db.Config{LockTimeout: 50 * time.Millisecond}, buff, buff, in, args)
+	if elapsed := time.Since(start); elapsed >= db.DefaultLockTimeout {
+		t.Errorf("elapsed, expected less than %v got %v", db.DefaultLockTimeout, elapsed)
+	}
+	if code != 0 {
+		t.Errorf("exit code, expected 0 got %d", code)
+	}
+	if !CheckOutputStrings(
+		"db > failed to open table self.db, "+db.ErrLockTimeout.Error(),
+		"db > failed to open table other.db, "+db.ErrLockTimeout.Error(),
+		"db > ",
+	).Check(t, buff.Bytes()) {
+		return
+	}
+}
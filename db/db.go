@@ -3,12 +3,18 @@ package db
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unsafe"
 )
 
@@ -18,6 +24,9 @@ const (
 	MetaCommandSuccess MetaCommand = iota
 	MetaCommandExit
 	MetaCommandUnrecognizedCommand
+	MetaCommandRecover
+	MetaCommandTimerOn
+	MetaCommandTimerOff
 )
 
 type PrepareResult uint
@@ -36,6 +45,9 @@ const (
 	ExecuteSuccess ExecuteResult = iota
 	ExecuteTableFull
 	ExecuteFailedFile
+	ExecuteNoFTSIndex
+	ExecuteNoSuchSavepoint
+	ExecuteNotNullViolation
 )
 
 type StatementType uint
@@ -43,6 +55,10 @@ type StatementType uint
 const (
 	StatementInsert StatementType = iota
 	StatementSelect
+	StatementCreateIndex
+	StatementSavepoint
+	StatementRollbackTo
+	StatementRelease
 )
 
 const (
@@ -56,8 +72,16 @@ const (
 	TableMaxPages = 100
 	RowsPerPage   = PageSize / RowSize
 	TableMaxRows  = RowsPerPage * TableMaxPages
+
+	// pageChecksumOffset is where the crc32 checksum of the rows is
+	// stored in the unused space at the end of a page. Pages written
+	// before checksums were added have zero there.
+	pageChecksumOffset = PageSize - 4
 )
 
+// make sure the checksum does not overlap the rows.
+const _ = uint(pageChecksumOffset - RowsPerPage*RowSize)
+
 type Row struct {
 	ID       uint32
 	Username [ColumnUsernameSize]byte
@@ -68,16 +92,49 @@ func (r Row) Seralize() [RowSize]byte {
 	return (*(*[RowSize]byte)(unsafe.Pointer(&r)))
 }
 
+// cstring returns the bytes of b up to the first null byte.
+func cstring(b []byte) string {
+	l := bytes.IndexByte(b, 0)
+	if l == -1 {
+		l = len(b)
+	}
+	return string(b[:l])
+}
+
+func (r Row) username() string { return cstring(r.Username[:]) }
+func (r Row) email() string    { return cstring(r.Email[:]) }
+
 func (r Row) String() string {
-	userLen := bytes.IndexByte(r.Username[:], 0)
-	if userLen == -1 {
-		userLen = ColumnUsernameSize
+	return fmt.Sprintf("(%d, %s, %s)", r.ID-1, r.username(), r.email())
+}
+
+// ColumnNames are the names of the columns of a Row, in order.
+var ColumnNames = []string{"id", "username", "email"}
+
+func isColumn(column string) bool {
+	for _, name := range ColumnNames {
+		if name == column {
+			return true
+		}
 	}
-	emailLen := bytes.IndexByte(r.Email[:], 0)
-	if emailLen == -1 {
-		emailLen = ColumnEmailSize
+	return false
+}
+
+// format returns the row like String, but with only the given columns.
+// No columns means all of them.
+func (r Row) format(columns []string) string {
+	if len(columns) == 0 {
+		return r.String()
+	}
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		if column == "id" {
+			values[i] = strconv.FormatUint(uint64(r.ID-1), 10)
+			continue
+		}
+		values[i] = r.varcharValue(column)
 	}
-	return fmt.Sprintf("(%d, %s, %s)", r.ID-1, r.Username[:userLen], r.Email[:emailLen])
+	return "(" + strings.Join(values, ", ") + ")"
 }
 
 func DeseralizeRow(source *[RowSize]byte) *Row {
@@ -89,8 +146,15 @@ type Pager struct {
 	backing *os.File
 	Length  int64
 	pages   [TableMaxPages]*Page
+	// reads is the number of pages that have been loaded from disk
+	reads int
+	// readOnly pagers are never written back to disk
+	readOnly bool
 }
 
+// Get returns the page, loading it from the backing file if it is not
+// in memory yet. A partial page at the end of the file was left by a
+// crash, so it is returned empty, and is overwritten when it is flushed.
 func (p *Pager) Get(pageNum int) (*Page, error) {
 	var (
 		pageByte [PageSize]byte
@@ -107,25 +171,17 @@ func (p *Pager) Get(pageNum int) (*Page, error) {
 	// Cache miss, Allocate memory and load from file
 	page = new(Page)
 
-	// We might save a partial page at the end of the file
-	if p.Length%PageSize != 0 {
-		numberOfPages++
-	}
-
 	if int64(pageNum) < numberOfPages {
 		// Need to load the page from the disk
-		bytesRead, err := p.backing.ReadAt(pageByte[:], int64(pageNum*PageSize))
+		_, err := p.backing.ReadAt(pageByte[:], int64(pageNum*PageSize))
 		if err != nil && err != io.EOF {
 			return nil, err
 		}
 		// convert to a page
 		for row := 0; row < int(RowsPerPage); row++ {
-			rowOffset := row * int(RowSize)
-			if rowOffset >= bytesRead {
-				break
-			}
-			copy(page[row][:], pageByte[rowOffset:])
+			copy(page[row][:], pageByte[row*int(RowSize):])
 		}
+		p.reads++
 	}
 
 	p.pages[pageNum] = page
@@ -139,6 +195,9 @@ func (p *Pager) Flush(pageNum int) error {
 	if pageNum > TableMaxPages {
 		return fmt.Errorf("Tried to flush page number out of bounds. %d > %d\n", pageNum, TableMaxPages)
 	}
+	if p.readOnly {
+		return errReadOnly
+	}
 	page := p.pages[pageNum]
 	if page == nil {
 		// nothing to do, page was never loaded from disk
@@ -149,6 +208,7 @@ func (p *Pager) Flush(pageNum int) error {
 
 		copy(pageByte[row*int(RowSize):], page[row][:])
 	}
+	binary.LittleEndian.PutUint32(pageByte[pageChecksumOffset:], pageChecksum(&pageByte))
 	//	p.backing.Seek(int64(pageNum)*PageSize, 0)
 	_, err := p.backing.WriteAt(pageByte[:], int64(pageNum)*PageSize)
 	if err != nil {
@@ -157,15 +217,83 @@ func (p *Pager) Flush(pageNum int) error {
 	return nil
 
 }
+
+// pageChecksum returns the checksum of the rows on the page.
+func pageChecksum(pageByte *[PageSize]byte) uint32 {
+	return crc32.ChecksumIEEE(pageByte[:RowsPerPage*RowSize])
+}
+
+// countRows returns the number of whole, non empty rows in b.
+func countRows(b []byte) int {
+	var (
+		rowByte [RowSize]byte
+		numRows int
+	)
+	for start := 0; start+int(RowSize) <= len(b); start += int(RowSize) {
+		copy(rowByte[:], b[start:])
+		if DeseralizeRow(&rowByte).ID != 0 {
+			numRows++
+		}
+	}
+	return numRows
+}
+
+// RecoverTruncated removes a partially written page from the end of the
+// backing file. The last page is partially written if the file ends part
+// way through it, or if its checksum does not match its rows. A page with
+// a zero checksum predates checksums, and is trusted. lostRows is the
+// number of rows that were on the removed data.
+func (p *Pager) RecoverTruncated() (lostRows int, err error) {
+	var (
+		pageByte [PageSize]byte
+	)
+	// pages in memory are whole, make sure they are on disk.
+	if err := p.SyncToDisk(); err != nil {
+		return 0, err
+	}
+	info, err := p.backing.Stat()
+	if err != nil {
+		return 0, err
+	}
+	length := info.Size()
+	if partial := length % PageSize; partial != 0 {
+		length -= partial
+		bytesRead, err := p.backing.ReadAt(pageByte[:partial], length)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		lostRows += countRows(pageByte[:bytesRead])
+	}
+	if length > 0 {
+		if _, err := p.backing.ReadAt(pageByte[:], length-PageSize); err != nil && err != io.EOF {
+			return 0, err
+		}
+		checksum := binary.LittleEndian.Uint32(pageByte[pageChecksumOffset:])
+		if checksum != 0 && checksum != pageChecksum(&pageByte) {
+			length -= PageSize
+			lostRows += countRows(pageByte[:RowsPerPage*RowSize])
+		}
+	}
+	if err := p.backing.Truncate(length); err != nil {
+		return 0, err
+	}
+	p.pages = [TableMaxPages]*Page{}
+	p.Length = length
+	return lostRows, nil
+}
+
+// numberOfRowsOnDisk counts the rows on the whole pages of the backing
+// file. Pages are always written out whole, so a partial page at the end
+// of the file was left by a crash, and is ignored like it is by Get.
 func (p *Pager) numberOfRowsOnDisk() int {
 	var (
 		pageByte [PageSize]byte
 		rowByte  [RowSize]byte
 	)
-	if p.Length == 0 {
+	var numberOfPages = (p.Length / PageSize)
+	if numberOfPages == 0 {
 		return 0
 	}
-	var numberOfPages = (p.Length / PageSize)
 	var lastPageOffset = (numberOfPages - 1) * PageSize
 	p.backing.Seek(lastPageOffset, 0)
 	bytesRead, err := p.backing.ReadAt(pageByte[:], lastPageOffset)
@@ -175,7 +303,7 @@ func (p *Pager) numberOfRowsOnDisk() int {
 	numRows := 0
 
 	if bytesRead == 0 {
-		return int((numberOfPages-1)/int64(RowsPerPage)) + numRows
+		return int((numberOfPages-1)*int64(RowsPerPage)) + numRows
 	}
 	for i := 0; i < int(RowsPerPage); i++ {
 		// check to see if the first byte is != 0
@@ -190,7 +318,7 @@ func (p *Pager) numberOfRowsOnDisk() int {
 		}
 		numRows++
 	}
-	return int((numberOfPages-1)/int64(RowsPerPage)) + numRows
+	return int((numberOfPages-1)*int64(RowsPerPage)) + numRows
 
 }
 
@@ -203,13 +331,33 @@ func (p *Pager) SyncToDisk() error {
 	return nil
 }
 
+// truncate writes out the first numPages pages, drops every cached page
+// and truncates the backing file to numPages pages. Pages will be
+// reloaded from disk as they are needed.
+func (p *Pager) truncate(numPages int) error {
+	for i := 0; i < numPages; i++ {
+		if err := p.Flush(i); err != nil {
+			return err
+		}
+	}
+	length := int64(numPages) * PageSize
+	if err := p.backing.Truncate(length); err != nil {
+		return err
+	}
+	p.pages = [TableMaxPages]*Page{}
+	p.Length = length
+	return nil
+}
+
 func (p *Pager) Close() error {
 	if p == nil || p.backing == nil {
 		return nil
 	}
 	// write out rows to disk
-	if err := p.SyncToDisk(); err != nil {
-		return err
+	if !p.readOnly {
+		if err := p.SyncToDisk(); err != nil {
+			return err
+		}
 	}
 
 	err := p.backing.Close()
@@ -217,13 +365,27 @@ func (p *Pager) Close() error {
 	return err
 }
 
-func NewPager(filename string) (*Pager, error) {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0744)
-	if err != nil {
-		return nil, err
-	}
+// ErrLockTimeout is returned by NewPagerWithTimeout when the database
+// file could not be locked in time.
+var ErrLockTimeout = errors.New("timed out waiting for database lock")
+
+// lockRetryInterval is how long NewPagerWithTimeout waits between
+// attempts to lock the database file.
+var lockRetryInterval = 10 * time.Millisecond
+
+var (
+	// ErrNotDatabase is returned when opening a file read only that
+	// is not a database file.
+	ErrNotDatabase = errors.New("file is not a database")
+
+	errReadOnly    = errors.New("pager is read only")
+	errInvalidFrom = errors.New("must name a .db file in the same directory as the database")
+)
+
+func newPager(file *os.File) (*Pager, error) {
 	length, err := file.Seek(0, 2)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 	return &Pager{
@@ -232,6 +394,92 @@ func NewPager(filename string) (*Pager, error) {
 	}, nil
 }
 
+// NewPager opens the database file, and takes an exclusive lock on it.
+// If another pager has the file locked, NewPager blocks until the lock
+// is released.
+func NewPager(filename string) (*Pager, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0744)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return newPager(file)
+}
+
+// NewPagerWithTimeout is like NewPager, but gives up waiting for the
+// lock after timeout, returning ErrLockTimeout.
+func NewPagerWithTimeout(filename string, timeout time.Duration) (*Pager, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0744)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFileWithTimeout(file, true, timeout); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return newPager(file)
+}
+
+// newReadOnlyPager opens an existing database file without ever writing
+// to it. The file is checked to be a database file: it must be made up of
+// whole pages, whose checksums match.
+func newReadOnlyPager(filename string, timeout time.Duration) (*Pager, error) {
+	var (
+		pageByte [PageSize]byte
+	)
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFileWithTimeout(file, false, timeout); err != nil {
+		file.Close()
+		return nil, err
+	}
+	pager, err := newPager(file)
+	if err != nil {
+		return nil, err
+	}
+	pager.readOnly = true
+	if pager.Length%PageSize != 0 {
+		pager.Close()
+		return nil, ErrNotDatabase
+	}
+	for offset := int64(0); offset < pager.Length; offset += PageSize {
+		if _, err := file.ReadAt(pageByte[:], offset); err != nil && err != io.EOF {
+			pager.Close()
+			return nil, err
+		}
+		checksum := binary.LittleEndian.Uint32(pageByte[pageChecksumOffset:])
+		if checksum != 0 && checksum != pageChecksum(&pageByte) {
+			pager.Close()
+			return nil, ErrNotDatabase
+		}
+	}
+	return pager, nil
+}
+
+// lockFileWithTimeout polls for a lock on the file until timeout has
+// passed, returning ErrLockTimeout if it was not acquired.
+func lockFileWithTimeout(file *os.File, exclusive bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := tryLockFile(file, exclusive)
+		if err == nil {
+			return nil
+		}
+		if err != errLocked {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return ErrLockTimeout
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
 type Cursor struct {
 	table      *Table
 	rowNumber  uint32
@@ -268,6 +516,29 @@ func (cur *Cursor) Value() (*[RowSize]byte, error) {
 type Table struct {
 	NumRows uint32
 	Pager   *Pager
+	// Schema describes the columns of the table, and their constraints.
+	Schema []ColumnDef
+	// ReplicationSlotSize is the number of changes buffered by slots
+	// created with CreateReplicationSlot. Zero means
+	// DefaultReplicationSlotSize.
+	ReplicationSlotSize int
+	// LockTimeout is how long a select waits for the lock on the file
+	// named by its from clause. Zero means DefaultLockTimeout.
+	LockTimeout time.Duration
+
+	// slotsMu guards slots, as slots may be dropped by their consumers
+	// from other goroutines.
+	slotsMu sync.Mutex
+	slots   map[string]*ReplicationSlot
+	// fts is the full-text index of the table, nil if there is none
+	fts *ftsIndex
+	// savepoints is the stack of savepoints, the most recent is last
+	savepoints []Savepoint
+}
+
+// Count returns the number of rows in the table.
+func (tbl *Table) Count() int {
+	return int(tbl.NumRows)
 }
 
 func (tbl *Table) RowSlot(rowNum uint32) (*[RowSize]byte, error) {
@@ -293,6 +564,92 @@ func (tbl *Table) insertRow(rowNum uint32, row *Row) error {
 	return nil
 }
 
+// Compact moves every live row into consecutive slots, starting at
+// the first page, so that no page has gaps left by empty rows. The
+// backing file is truncated to the pages that are still in use. A
+// ChangeMove is published for every row that was moved. Savepoints are
+// kept, and rolling back to one still removes just the rows inserted
+// after it was taken.
+func (tbl *Table) Compact() error {
+	var (
+		live  uint32
+		moves []ChangeEvent
+		// savepoints is the number of live rows before each savepoint,
+		// which is where the savepoint ends once the rows are moved.
+		savepoints = make([]uint32, len(tbl.savepoints))
+	)
+	for rowNum := uint32(0); rowNum < tbl.NumRows; rowNum++ {
+		src, err := tbl.RowSlot(rowNum)
+		if err != nil {
+			return err
+		}
+		if DeseralizeRow(src).ID == 0 {
+			continue
+		}
+		for i, sp := range tbl.savepoints {
+			if rowNum < sp.NumRows {
+				savepoints[i]++
+			}
+		}
+		if live != rowNum {
+			dst, err := tbl.RowSlot(live)
+			if err != nil {
+				return err
+			}
+			*dst = *src
+			moves = append(moves, ChangeEvent{
+				Type:         ChangeMove,
+				RowNumber:    live,
+				OldRowNumber: rowNum,
+				Row:          *DeseralizeRow(dst),
+			})
+		}
+		live++
+	}
+
+	numPages := (live + RowsPerPage - 1) / RowsPerPage
+	// clear out the slots on the last page that are no longer used
+	for rowNum := live; rowNum < tbl.NumRows && rowNum < numPages*RowsPerPage; rowNum++ {
+		slot, err := tbl.RowSlot(rowNum)
+		if err != nil {
+			return err
+		}
+		*slot = [RowSize]byte{}
+	}
+	tbl.NumRows = live
+	for i := range tbl.savepoints {
+		tbl.savepoints[i].NumRows = savepoints[i]
+	}
+	if err := tbl.Pager.truncate(int(numPages)); err != nil {
+		return err
+	}
+	for _, move := range moves {
+		tbl.publish(move)
+	}
+	if tbl.fts != nil {
+		// row numbers have changed
+		return tbl.buildFTSIndex(tbl.fts.Column)
+	}
+	return nil
+}
+
+// Recover removes any partially written data from the end of the table,
+// see Pager.RecoverTruncated, and returns the number of rows that were lost.
+func (tbl *Table) Recover() (lostRows int, err error) {
+	lostRows, err = tbl.Pager.RecoverTruncated()
+	if err != nil {
+		return 0, err
+	}
+	tbl.NumRows = uint32(tbl.Pager.numberOfRowsOnDisk())
+	if tbl.fts != nil {
+		// rows may have been removed from the index
+		if err := tbl.buildFTSIndex(tbl.fts.Column); err != nil {
+			return lostRows, err
+		}
+	}
+	return lostRows, nil
+}
+
 func (tbl *Table) Close() (err error) {
 	defer func() {
 		if err != nil {
@@ -303,6 +660,11 @@ func (tbl *Table) Close() (err error) {
 		return nil
 	}
 
+	if tbl.fts != nil && tbl.fts.dirty {
+		if err = tbl.fts.save(); err != nil {
+			return err
+		}
+	}
 	if err = tbl.Pager.Close(); err != nil {
 		return err
 	}
@@ -325,17 +687,54 @@ func (tbl *Table) CursorAtEnd() *Cursor {
 	}
 }
 
+// DefaultLockTimeout is how long DBOpen waits for another process to
+// release its lock on the database file.
+const DefaultLockTimeout = time.Second
+
+// DBOpen opens the table in filename, returning ErrLockTimeout if the
+// file is still locked after DefaultLockTimeout.
 func DBOpen(filename string) (*Table, error) {
-	pager, err := NewPager(filename)
+	return DBOpenWithTimeout(filename, DefaultLockTimeout)
+}
+
+// DBOpenWithTimeout is like DBOpen, but waits up to timeout for the
+// lock on the database file. The table's LockTimeout is set to timeout.
+func DBOpenWithTimeout(filename string, timeout time.Duration) (*Table, error) {
+	pager, err := NewPagerWithTimeout(filename, timeout)
 	if err != nil {
 		return nil, err
 	}
+	tbl, err := newTable(pager, filename)
+	if err != nil {
+		return nil, err
+	}
+	tbl.LockTimeout = timeout
+	return tbl, nil
+}
+
+// dbOpenReadOnly opens the table in filename for reading only.
+func dbOpenReadOnly(filename string, timeout time.Duration) (*Table, error) {
+	pager, err := newReadOnlyPager(filename, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newTable(pager, filename)
+}
+
+func newTable(pager *Pager, filename string) (*Table, error) {
 	numberOfRows := uint32(pager.numberOfRowsOnDisk())
+	fts, err := loadFTSIndex(ftsIndexPath(filename))
+	if err != nil {
+		pager.Close()
+		return nil, err
+	}
 	// numberOfRows may be too big, we need to see if
 	// the last page only has a few rows.
 	return &Table{
 		NumRows: numberOfRows,
 		Pager:   pager,
+		Schema:  DefaultSchema(),
+		fts:     fts,
 	}, nil
 }
 
@@ -343,6 +742,31 @@ type Statement struct {
 	Type StatementType
 	// InsertRow is only used by insert statement
 	InsertRow *Row
+	// Returning is only used by insert statement, and causes the
+	// inserted row to be printed once it has been written.
+	Returning bool
+	// Column is the column a full-text index is created on, or
+	// that Match is done against.
+	Column string
+	// Match is only used by select statement, and limits the rows
+	// to those with a token in Column starting with Match.
+	Match string
+	// Columns is only used by select statement, and are the columns
+	// to return. Empty means all columns.
+	Columns []string
+	// From is only used by select statement, and is the name of a .db
+	// file, in the directory of the current database file, to select
+	// from instead of the current table. The .db extension may be left
+	// off.
+	From string
+	// UnionStmt is only used by select statement, and is a select
+	// whose rows are added to the rows of this statement.
+	UnionStmt *Statement
+	// UnionAll keeps duplicate rows when combining with UnionStmt.
+	UnionAll bool
+	// Name is the name of the savepoint used by the savepoint,
+	// rollback to and release statements.
+	Name string
 }
 
 func printPrompt(out io.Writer) {
@@ -353,11 +777,153 @@ func doMetaCommand(input string) MetaCommand {
 	switch input {
 	case ".exit":
 		return MetaCommandExit
+	case ".recover":
+		return MetaCommandRecover
+	case ".timer on":
+		return MetaCommandTimerOn
+	case ".timer off":
+		return MetaCommandTimerOff
 	default:
 		return MetaCommandUnrecognizedCommand
 	}
 }
 
+// prepareColumns parses a comma separated list of column names, or *.
+func prepareColumns(input string) ([]string, PrepareResult) {
+	if strings.TrimSpace(input) == "*" {
+		return nil, PrepareSuccess
+	}
+	columns := strings.Split(input, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+		if !isColumn(columns[i]) {
+			return nil, PrepareSyntaxError
+		}
+	}
+	return columns, PrepareSuccess
+}
+
+// numColumns returns the number of columns the select statement returns.
+func (statement *Statement) numColumns() int {
+	if len(statement.Columns) == 0 {
+		return len(ColumnNames)
+	}
+	return len(statement.Columns)
+}
+
+// prepareSelect parses a select statement of the form:
+//
+//	select [* | <column>, ...] [from <file>] [where <column> match '<prefix>']
+//		[union [all] <select>]
+func prepareSelect(input string) (*Statement, PrepareResult) {
+	fields := strings.Fields(input)
+	if fields[0] != "select" {
+		return nil, PrepareUnrecognizedStatement
+	}
+	statement := &Statement{Type: StatementSelect}
+	fields = fields[1:]
+
+	// everything after union is another select statement
+	for i, field := range fields {
+		if field != "union" {
+			continue
+		}
+		rest := fields[i+1:]
+		if len(rest) > 0 && rest[0] == "all" {
+			statement.UnionAll = true
+			rest = rest[1:]
+		}
+		if len(rest) == 0 || rest[0] != "select" {
+			return nil, PrepareSyntaxError
+		}
+		union, result := prepareSelect(strings.Join(rest, " "))
+		if result != PrepareSuccess {
+			return nil, result
+		}
+		statement.UnionStmt = union
+		fields = fields[:i]
+		break
+	}
+
+	// the columns run up to the from or where clause
+	end := len(fields)
+	for i, field := range fields {
+		if field == "from" || field == "where" {
+			end = i
+			break
+		}
+	}
+	if end > 0 {
+		columns, result := prepareColumns(strings.Join(fields[:end], " "))
+		if result != PrepareSuccess {
+			return nil, result
+		}
+		statement.Columns = columns
+	}
+	fields = fields[end:]
+
+	if len(fields) > 0 && fields[0] == "from" {
+		if len(fields) < 2 {
+			return nil, PrepareSyntaxError
+		}
+		statement.From = fields[1]
+		fields = fields[2:]
+	}
+	if statement.UnionStmt != nil && statement.UnionStmt.numColumns() != statement.numColumns() {
+		return nil, PrepareSyntaxError
+	}
+
+	switch {
+	case len(fields) == 0:
+		return statement, PrepareSuccess
+	case len(fields) == 4 && fields[2] == "match":
+		term := fields[3]
+		if !isVarcharColumn(fields[1]) || len(term) < 3 ||
+			term[0] != '\'' || term[len(term)-1] != '\'' {
+			return nil, PrepareSyntaxError
+		}
+		statement.Column = fields[1]
+		statement.Match = term[1 : len(term)-1]
+		return statement, PrepareSuccess
+	default:
+		return nil, PrepareSyntaxError
+	}
+}
+
+// prepareSavepoint parses the savepoint statements:
+//
+//	savepoint <name>
+//	rollback to [savepoint] <name>
+//	release [savepoint] <name>
+func prepareSavepoint(input string) (*Statement, PrepareResult) {
+	fields := strings.Fields(input)
+	var statement Statement
+	switch fields[0] {
+	case "savepoint":
+		statement.Type = StatementSavepoint
+		fields = fields[1:]
+	case "rollback":
+		if len(fields) < 2 || fields[1] != "to" {
+			return nil, PrepareSyntaxError
+		}
+		statement.Type = StatementRollbackTo
+		fields = fields[2:]
+	case "release":
+		statement.Type = StatementRelease
+		fields = fields[1:]
+	default:
+		return nil, PrepareUnrecognizedStatement
+	}
+	if statement.Type != StatementSavepoint && len(fields) == 2 && fields[0] == "savepoint" {
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return nil, PrepareSyntaxError
+	}
+	statement.Name = fields[0]
+	return &statement, PrepareSuccess
+}
+
 func prepareStatement(input string) (*Statement, PrepareResult) {
 	switch {
 	case strings.HasPrefix(input, "insert"):
@@ -382,6 +948,14 @@ func prepareStatement(input string) (*Statement, PrepareResult) {
 			return nil, PrepareNegativeID
 		}
 
+		var returning bool
+		if fields := strings.Fields(input); len(fields) > 4 {
+			if len(fields) != 6 || fields[4] != "returning" || fields[5] != "*" {
+				return nil, PrepareSyntaxError
+			}
+			returning = true
+		}
+
 		r := Row{ID: uint32(id + 1)}
 		copy(r.Username[:], []byte(username))
 		copy(r.Email[:], []byte(email))
@@ -389,9 +963,27 @@ func prepareStatement(input string) (*Statement, PrepareResult) {
 		return &Statement{
 			Type:      StatementInsert,
 			InsertRow: &r,
+			Returning: returning,
 		}, PrepareSuccess
 	case strings.HasPrefix(input, "select"):
-		return &Statement{Type: StatementSelect}, PrepareSuccess
+		return prepareSelect(input)
+	case strings.HasPrefix(input, "savepoint"),
+		strings.HasPrefix(input, "rollback"),
+		strings.HasPrefix(input, "release"):
+		return prepareSavepoint(input)
+	case strings.HasPrefix(input, "create"):
+		const prefix, suffix = "create fts index on rows(", ")"
+		if !strings.HasPrefix(input, prefix) || !strings.HasSuffix(input, suffix) {
+			return nil, PrepareSyntaxError
+		}
+		column := strings.TrimSuffix(strings.TrimPrefix(input, prefix), suffix)
+		if !isVarcharColumn(column) {
+			return nil, PrepareSyntaxError
+		}
+		return &Statement{
+			Type:   StatementCreateIndex,
+			Column: column,
+		}, PrepareSuccess
 	default:
 		return nil, PrepareUnrecognizedStatement
 	}
@@ -401,25 +993,162 @@ func (tbl *Table) executeInsert(out io.Writer, statement *Statement) ExecuteResu
 	if tbl.NumRows >= TableMaxRows {
 		return ExecuteTableFull
 	}
-	tbl.insertRow(tbl.NumRows, statement.InsertRow)
+	if tbl.checkNotNull(statement.InsertRow) != "" {
+		return ExecuteNotNullViolation
+	}
+	rowNum := tbl.NumRows
+	if err := tbl.insertRow(rowNum, statement.InsertRow); err != nil {
+		fmt.Fprintf(out, "failed to insert row, %v", err)
+		return ExecuteFailedFile
+	}
 	tbl.NumRows += 1
+	if tbl.fts != nil {
+		tbl.fts.add(rowNum, statement.InsertRow)
+	}
+	tbl.publish(ChangeEvent{
+		Type:      ChangeInsert,
+		RowNumber: rowNum,
+		Row:       *statement.InsertRow,
+	})
+	if statement.Returning {
+		rowbyte, err := tbl.RowSlot(rowNum)
+		if err != nil {
+			fmt.Fprintf(out, "failed to get row, %v", err)
+			return ExecuteFailedFile
+		}
+		fmt.Fprintln(out, DeseralizeRow(rowbyte))
+	}
 	return ExecuteSuccess
 }
 
-func (tbl *Table) executeSelect(out io.Writer, statement *Statement) ExecuteResult {
+// selectRows calls fn for each row selected by the statement, starting
+// at row number start. Iteration stops early if fn returns false.
+func (tbl *Table) selectRows(statement *Statement, start uint32, fn func(rowNum uint32, row *Row) bool) error {
+	if statement.Match != "" {
+		return tbl.matchRows(statement.Column, statement.Match, start, fn)
+	}
 	cursor := tbl.CursorAtStart()
+	cursor.rowNumber = start
+	if start >= tbl.NumRows {
+		cursor.EndOfTable = true
+	}
 	for !cursor.EndOfTable {
 
 		rowbyte, err := cursor.Value() //tbl.RowSlot(i)
 		if err != nil {
-			fmt.Fprintf(out, "failed to get row, %v", err)
-			return ExecuteFailedFile
+			return err
 		}
 		row := DeseralizeRow(rowbyte)
-		fmt.Fprintln(out, row)
+		// skip over empty slots
+		if row.ID != 0 && !fn(cursor.rowNumber, row) {
+			return nil
+		}
 
 		cursor.Advance()
 	}
+	return nil
+}
+
+// openFrom opens the table named by the from clause of a select for
+// reading. A name without an extension is given the .db extension. If it
+// names the database file of tbl, tbl is returned.
+func (tbl *Table) openFrom(from string) (*Table, error) {
+	if filepath.Ext(from) == "" {
+		from += ".db"
+	}
+	if from != filepath.Base(from) || filepath.Ext(from) != ".db" {
+		return nil, errInvalidFrom
+	}
+	filename := tbl.Pager.backing.Name()
+	from = filepath.Join(filepath.Dir(filename), from)
+	if from == filepath.Clean(filename) {
+		return tbl, nil
+	}
+	timeout := tbl.LockTimeout
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+	return dbOpenReadOnly(from, timeout)
+}
+
+// executeSelect writes out the rows of the statement, followed by the
+// rows of any statements it is unioned with. Unions are left associative,
+// so duplicate rows are only written out once by the selects up to the
+// last union that is not a union all; the selects after it are written
+// out in full.
+func (tbl *Table) executeSelect(out io.Writer, statement *Statement) ExecuteResult {
+	// dedup is the number of selects, from the first, that are combined
+	// without duplicates.
+	var dedup int
+	for i, stmt := 0, statement; stmt.UnionStmt != nil; i, stmt = i+1, stmt.UnionStmt {
+		if !stmt.UnionAll {
+			dedup = i + 2
+		}
+	}
+	// open every table before writing out any rows, so one that can not
+	// be opened does not leave a partial result.
+	var srcs []*Table
+	defer func() {
+		for _, src := range srcs {
+			if src != tbl {
+				src.Close()
+			}
+		}
+	}()
+	for stmt := statement; stmt != nil; stmt = stmt.UnionStmt {
+		src := tbl
+		if stmt.From != "" {
+			var err error
+			src, err = tbl.openFrom(stmt.From)
+			if err != nil {
+				fmt.Fprintf(out, "failed to open table %s, %v\n", stmt.From, err)
+				return ExecuteFailedFile
+			}
+		}
+		srcs = append(srcs, src)
+	}
+	seen := make(map[string]struct{})
+	for i, stmt := 0, statement; stmt != nil; i, stmt = i+1, stmt.UnionStmt {
+		if i == dedup {
+			seen = nil
+		}
+		if result := srcs[i].executeSingleSelect(out, stmt, seen); result != ExecuteSuccess {
+			return result
+		}
+	}
+	return ExecuteSuccess
+}
+
+// executeSingleSelect writes out the rows of the statement from tbl,
+// ignoring its from clause and union. If seen is not nil, rows that are
+// in seen are skipped and the rows written out are added to it.
+func (tbl *Table) executeSingleSelect(out io.Writer, statement *Statement, seen map[string]struct{}) ExecuteResult {
+	err := tbl.selectRows(statement, 0, func(_ uint32, row *Row) bool {
+		line := row.format(statement.Columns)
+		if seen != nil {
+			if _, ok := seen[line]; ok {
+				return true
+			}
+			seen[line] = struct{}{}
+		}
+		fmt.Fprintln(out, line)
+		return true
+	})
+	if err == ErrNoFTSIndex {
+		return ExecuteNoFTSIndex
+	}
+	if err != nil {
+		fmt.Fprintf(out, "failed to get row, %v", err)
+		return ExecuteFailedFile
+	}
+	return ExecuteSuccess
+}
+
+func (tbl *Table) executeCreateIndex(out io.Writer, statement *Statement) ExecuteResult {
+	if err := tbl.buildFTSIndex(statement.Column); err != nil {
+		fmt.Fprintf(out, "failed to create index, %v", err)
+		return ExecuteFailedFile
+	}
 	return ExecuteSuccess
 }
 
@@ -432,18 +1161,83 @@ func executeStatement(out io.Writer, statement *Statement, table *Table) Execute
 		return table.executeInsert(out, statement)
 	case StatementSelect:
 		return table.executeSelect(out, statement)
+	case StatementCreateIndex:
+		return table.executeCreateIndex(out, statement)
+	case StatementSavepoint:
+		return table.executeSavepoint(out, statement)
+	case StatementRollbackTo:
+		return table.executeRollbackTo(out, statement)
+	case StatementRelease:
+		return table.executeRelease(out, statement)
 	default:
 		return ExecuteSuccess
 	}
 }
 
+// DefaultMaxStatementLen is the default value of Config.MaxStatementLen.
+// It matches the default buffer size of bufio.Scanner.
+const DefaultMaxStatementLen = bufio.MaxScanTokenSize
+
+// Config holds the settings used by MainWithConfig.
+type Config struct {
+	// MaxStatementLen is the longest statement, in bytes, that will be
+	// executed. Longer statements are skipped without being fully read
+	// into memory. Zero means DefaultMaxStatementLen.
+	MaxStatementLen int
+	// LockTimeout is how long to wait for another process to release
+	// the database file, or a file selected from. Zero means
+	// DefaultLockTimeout.
+	LockTimeout time.Duration
+}
+
+// scanStatements returns a bufio.SplitFunc that splits input into lines
+// like bufio.ScanLines. Lines longer than maxLen are discarded as they
+// are read; only their first maxLen+1 bytes are returned so the caller
+// can tell the statement was too long.
+func scanStatements(maxLen int) bufio.SplitFunc {
+	// overflow holds the start of a line that is being discarded.
+	var overflow []byte
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if overflow == nil {
+			if bytes.IndexByte(data, '\n') == -1 && len(data) > maxLen {
+				overflow = append([]byte(nil), data[:maxLen+1]...)
+				return len(data), nil, nil
+			}
+			return bufio.ScanLines(data, atEOF)
+		}
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			token, overflow = overflow, nil
+			return i + 1, token, nil
+		}
+		if atEOF {
+			token, overflow = overflow, nil
+			return len(data), token, nil
+		}
+		return len(data), nil, nil
+	}
+}
+
 func Main(stdout, stderr io.Writer, stdin io.Reader, args []string) int {
+	return MainWithConfig(Config{}, stdout, stderr, stdin, args)
+}
+
+func MainWithConfig(cfg Config, stdout, stderr io.Writer, stdin io.Reader, args []string) int {
+	if cfg.MaxStatementLen <= 0 {
+		cfg.MaxStatementLen = DefaultMaxStatementLen
+	}
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = DefaultLockTimeout
+	}
 	if len(args) != 2 {
 		fmt.Fprintf(stderr, "Must supply a database filename.\n")
 		return 2
 	}
 
-	table, err := DBOpen(args[1])
+	table, err := DBOpenWithTimeout(args[1], cfg.LockTimeout)
+	if err == ErrLockTimeout {
+		fmt.Fprintf(stderr, "Database file(%v) is locked by another process.\n", args[1])
+		return 2
+	}
 	if err != nil {
 		fmt.Fprintf(stderr, "Failed to open database file(%v): %v", args[1], err)
 		return 2
@@ -451,11 +1245,29 @@ func Main(stdout, stderr io.Writer, stdin io.Reader, args []string) int {
 	defer table.Close()
 
 	scanner := bufio.NewScanner(stdin)
+	// leave room for the line ending and the byte that marks a statement
+	// as too long.
+	bufSize := cfg.MaxStatementLen + 2
+	initSize := 4096
+	if bufSize < initSize {
+		initSize = bufSize
+	}
+	scanner.Buffer(make([]byte, 0, initSize), bufSize)
+	scanner.Split(scanStatements(cfg.MaxStatementLen))
+	// timer is set by .timer, and causes the time taken by each statement
+	// to be printed.
+	var timer bool
 	for {
 		printPrompt(stdout)
-		scanner.Scan()
+		if !scanner.Scan() {
+			break
+		}
 
 		input := scanner.Text()
+		if len(input) > cfg.MaxStatementLen {
+			fmt.Fprintln(stderr, "Error: Statement too long.")
+			continue
+		}
 		if input == "" {
 			continue
 		}
@@ -466,6 +1278,17 @@ func Main(stdout, stderr io.Writer, stdin io.Reader, args []string) int {
 				return 0
 			case MetaCommandUnrecognizedCommand:
 				fmt.Fprintf(stderr, "Unrecognized command '%s'.\n", input)
+			case MetaCommandRecover:
+				lostRows, err := table.Recover()
+				if err != nil {
+					fmt.Fprintf(stderr, "Error: Failed to recover: %v\n", err)
+					break
+				}
+				fmt.Fprintf(stdout, "Recovered database, %d rows lost.\n", lostRows)
+			case MetaCommandTimerOn:
+				timer = true
+			case MetaCommandTimerOff:
+				timer = false
 			case MetaCommandSuccess:
 			}
 			continue
@@ -489,11 +1312,23 @@ func Main(stdout, stderr io.Writer, stdin io.Reader, args []string) int {
 			continue
 		}
 
-		switch executeStatement(stdout, statement, table) {
+		start := time.Now()
+		executeResult := executeStatement(stdout, statement, table)
+		elapsed := time.Since(start)
+		switch executeResult {
 		case ExecuteSuccess:
 			fmt.Fprintln(stdout, "Executed.")
 		case ExecuteTableFull:
 			fmt.Fprintln(stderr, "Error: Table full.")
+		case ExecuteNoFTSIndex:
+			fmt.Fprintf(stderr, "Error: No full-text index on %s.\n", statement.Column)
+		case ExecuteNoSuchSavepoint:
+			fmt.Fprintf(stderr, "Error: No such savepoint: %s.\n", statement.Name)
+		case ExecuteNotNullViolation:
+			fmt.Fprintf(stderr, "Error: NOT NULL constraint failed: %s.\n", table.checkNotNull(statement.InsertRow))
+		}
+		if timer {
+			fmt.Fprintf(stdout, "Run Time: Real: %.3f\n", elapsed.Seconds())
 		}
 
 	}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestTable opens a new table in a temporary directory, and returns
+// a func that closes the table and cleans up the directory.
+func openTestTable(t *testing.T) (*Table, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "dbtest")
+	if err != nil {
+		panic(err)
+	}
+	tbl, err := DBOpen(filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("open, expected nil got %v", err)
+	}
+	return tbl, func() {
+		tbl.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// mustExec prepares and executes the input against the table, and
+// returns anything the statement wrote out.
+func mustExec(t *testing.T, tbl *Table, input string) string {
+	t.Helper()
+	statement, result := prepareStatement(input)
+	if result != PrepareSuccess {
+		t.Fatalf("prepare '%s', expected success got %v", input, result)
+	}
+	buff := new(bytes.Buffer)
+	if result := executeStatement(buff, statement, tbl); result != ExecuteSuccess {
+		t.Fatalf("execute '%s', expected success got %v", input, result)
+	}
+	return buff.String()
+}
+
+func TestTable_Compact(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+
+	for i := 0; i < 20; i++ {
+		mustExec(t, tbl, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	slot, err := tbl.CreateReplicationSlot("compact")
+	if err != nil {
+		t.Fatalf("create slot, expected nil got %v", err)
+	}
+	// empty out every other row to leave gaps in the pages
+	for i := uint32(1); i < 20; i += 2 {
+		slot, err := tbl.RowSlot(i)
+		if err != nil {
+			t.Fatalf("row slot, expected nil got %v", err)
+		}
+		*slot = [RowSize]byte{}
+	}
+
+	if err := tbl.Compact(); err != nil {
+		t.Fatalf("compact, expected nil got %v", err)
+	}
+	// row 0 stays where it is, every other live row moves.
+	changes := slot.Changes()
+	if len(changes) != 9 {
+		t.Errorf("changes, expected 9 got %d", len(changes))
+	}
+	for i, change := range changes {
+		newRow, oldRow := uint32(i+1), uint32(i+1)*2
+		if change.Type != ChangeMove || change.RowNumber != newRow || change.OldRowNumber != oldRow {
+			t.Errorf("change %d, expected move of %d to %d got %+v", i, oldRow, newRow, change)
+		}
+	}
+	if tbl.NumRows != 10 {
+		t.Errorf("num rows, expected 10 got %d", tbl.NumRows)
+	}
+	if tbl.Pager.Length != PageSize {
+		t.Errorf("length, expected %d got %d", PageSize, tbl.Pager.Length)
+	}
+	for i := uint32(0); i < RowsPerPage; i++ {
+		slot, err := tbl.RowSlot(i)
+		if err != nil {
+			t.Fatalf("row slot, expected nil got %v", err)
+		}
+		row := DeseralizeRow(slot)
+		switch {
+		case i < tbl.NumRows && row.ID != i*2+1:
+			t.Errorf("row %d, expected id %d got %d", i, i*2, row.ID-1)
+		case i >= tbl.NumRows && row.ID != 0:
+			t.Errorf("row %d, expected an empty slot got %v", i, row)
+		}
+	}
+}
+
+func TestPager_RecoverTruncated(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+	filename := tbl.Pager.backing.Name()
+
+	for i := 0; i < 20; i++ {
+		mustExec(t, tbl, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	if err := tbl.Close(); err != nil {
+		t.Fatalf("close, expected nil got %v", err)
+	}
+
+	// corrupt a row on the last page so its checksum no longer matches.
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open, expected nil got %v", err)
+	}
+	if _, err := f.WriteAt([]byte("garbage"), PageSize+int64(RowSize)+4); err != nil {
+		t.Fatalf("write, expected nil got %v", err)
+	}
+	f.Close()
+
+	tbl, err = DBOpen(filename)
+	if err != nil {
+		t.Fatalf("open, expected nil got %v", err)
+	}
+	defer tbl.Close()
+	if tbl.NumRows != 20 {
+		t.Errorf("num rows, expected 20 got %d", tbl.NumRows)
+	}
+	lostRows, err := tbl.Recover()
+	if err != nil {
+		t.Fatalf("recover, expected nil got %v", err)
+	}
+	if lostRows != 20-int(RowsPerPage) {
+		t.Errorf("lost rows, expected %d got %d", 20-RowsPerPage, lostRows)
+	}
+	if tbl.NumRows != RowsPerPage {
+		t.Errorf("num rows, expected %d got %d", RowsPerPage, tbl.NumRows)
+	}
+	if tbl.Pager.Length != PageSize {
+		t.Errorf("length, expected %d got %d", PageSize, tbl.Pager.Length)
+	}
+}
+
+func TestPager_PartialPage(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+	filename := tbl.Pager.backing.Name()
+
+	for i := 0; i < int(RowsPerPage); i++ {
+		mustExec(t, tbl, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	if err := tbl.Close(); err != nil {
+		t.Fatalf("close, expected nil got %v", err)
+	}
+
+	// simulate a crash part way through writing out a second page.
+	var torn []byte
+	for _, id := range []uint32{100, 101} {
+		row := Row{ID: id + 1}
+		copy(row.Username[:], "ghost")
+		rowByte := row.Seralize()
+		torn = append(torn, rowByte[:]...)
+	}
+	torn = append(torn, "garbage"...)
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open, expected nil got %v", err)
+	}
+	if _, err := f.WriteAt(torn, PageSize); err != nil {
+		t.Fatalf("write, expected nil got %v", err)
+	}
+	f.Close()
+
+	// insert without recovering, the torn rows must not come back.
+	tbl, err = DBOpen(filename)
+	if err != nil {
+		t.Fatalf("open, expected nil got %v", err)
+	}
+	mustExec(t, tbl, "insert 14 user14 person14@example.com")
+	if err := tbl.Close(); err != nil {
+		t.Fatalf("close, expected nil got %v", err)
+	}
+	tbl, err = DBOpen(filename)
+	if err != nil {
+		t.Fatalf("open, expected nil got %v", err)
+	}
+	defer tbl.Close()
+	if tbl.NumRows != RowsPerPage+1 {
+		t.Errorf("num rows, expected %d got %d", RowsPerPage+1, tbl.NumRows)
+	}
+	if got := mustExec(t, tbl, "select"); strings.Contains(got, "ghost") {
+		t.Errorf("select, expected no torn rows got \n`%s`", got)
+	}
+}
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrNoFTSIndex is returned when a match is done against a column that
+// does not have a full-text index.
+var ErrNoFTSIndex = errors.New("no full-text index on column")
+
+// ftsIndex is an inverted index of the tokens in a varchar column to the
+// row numbers that contain them.
+type ftsIndex struct {
+	Column string
+	Tokens map[string][]uint32
+
+	path  string
+	dirty bool
+}
+
+// ftsIndexPath returns the path of the full-text index for the database
+// file; for "test.db" it is "test-fts.idx".
+func ftsIndexPath(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + "-fts.idx"
+}
+
+// isVarcharColumn reports whether column names one of the varchar columns of Row.
+func isVarcharColumn(column string) bool {
+	return column == "username" || column == "email"
+}
+
+func (r Row) varcharValue(column string) string {
+	switch column {
+	case "username":
+		return r.username()
+	case "email":
+		return r.email()
+	default:
+		return ""
+	}
+}
+
+// normalizeToken lowercases the token and strips punctuation from it.
+func normalizeToken(token string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, token)
+}
+
+// tokenize splits s on spaces into normalized tokens.
+func tokenize(s string) []string {
+	var tokens []string
+	for _, field := range strings.Fields(s) {
+		if token := normalizeToken(field); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func (idx *ftsIndex) add(rowNum uint32, row *Row) {
+	for _, token := range tokenize(row.varcharValue(idx.Column)) {
+		rows := idx.Tokens[token]
+		if len(rows) != 0 && rows[len(rows)-1] == rowNum {
+			// token is repeated in the value
+			continue
+		}
+		idx.Tokens[token] = append(rows, rowNum)
+	}
+	idx.dirty = true
+}
+
+// lookup returns the sorted row numbers that have a token starting with prefix.
+func (idx *ftsIndex) lookup(prefix string) []uint32 {
+	prefix = normalizeToken(prefix)
+	seen := make(map[uint32]struct{})
+	var rows []uint32
+	for token, tokenRows := range idx.Tokens {
+		if !strings.HasPrefix(token, prefix) {
+			continue
+		}
+		for _, rowNum := range tokenRows {
+			if _, ok := seen[rowNum]; ok {
+				continue
+			}
+			seen[rowNum] = struct{}{}
+			rows = append(rows, rowNum)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i] < rows[j] })
+	return rows
+}
+
+func (idx *ftsIndex) save() error {
+	file, err := os.Create(idx.path)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(idx); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	idx.dirty = false
+	return nil
+}
+
+// loadFTSIndex reads the index at path, if there is no index at path
+// nil is returned.
+func loadFTSIndex(path string) (*ftsIndex, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	idx := new(ftsIndex)
+	if err := gob.NewDecoder(file).Decode(idx); err != nil {
+		return nil, err
+	}
+	if idx.Tokens == nil {
+		idx.Tokens = make(map[string][]uint32)
+	}
+	idx.path = path
+	return idx, nil
+}
+
+// buildFTSIndex replaces the table's full-text index with a new index
+// of column, and saves it to disk.
+func (tbl *Table) buildFTSIndex(column string) error {
+	idx := &ftsIndex{
+		Column: column,
+		Tokens: make(map[string][]uint32),
+		path:   ftsIndexPath(tbl.Pager.backing.Name()),
+	}
+	err := tbl.selectRows(&Statement{Type: StatementSelect}, 0, func(rowNum uint32, row *Row) bool {
+		idx.add(rowNum, row)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err := idx.save(); err != nil {
+		return err
+	}
+	tbl.fts = idx
+	return nil
+}
+
+// matchRows calls fn for each row, at or after start, whose column has a
+// token starting with prefix.
+func (tbl *Table) matchRows(column, prefix string, start uint32, fn func(rowNum uint32, row *Row) bool) error {
+	if tbl.fts == nil || tbl.fts.Column != column {
+		return ErrNoFTSIndex
+	}
+	for _, rowNum := range tbl.fts.lookup(prefix) {
+		if rowNum < start || rowNum >= tbl.NumRows {
+			continue
+		}
+		rowbyte, err := tbl.RowSlot(rowNum)
+		if err != nil {
+			return err
+		}
+		row := DeseralizeRow(rowbyte)
+		if row.ID != 0 && !fn(rowNum, row) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTable_FTSIndex(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+	filename := tbl.Pager.backing.Name()
+
+	for _, input := range []string{
+		"insert 0 alice alice@example.com",
+		"insert 1 bob bob@example.com",
+		"insert 2 Ali-Baba ali@example.com",
+		"insert 3 carol carol@example.com",
+		"insert 4 Malik malik@example.com",
+	} {
+		mustExec(t, tbl, input)
+	}
+	statement, result := prepareStatement("select where username match 'ali'")
+	if result != PrepareSuccess {
+		t.Fatalf("prepare, expected success got %v", result)
+	}
+	if result := tbl.executeSelect(ioutil.Discard, statement); result != ExecuteNoFTSIndex {
+		t.Errorf("select without index, expected %v got %v", ExecuteNoFTSIndex, result)
+	}
+
+	mustExec(t, tbl, "create fts index on rows(username)")
+	if _, err := os.Stat(ftsIndexPath(filename)); err != nil {
+		t.Fatalf("index file, expected nil got %v", err)
+	}
+	// rows inserted after the index is created should be indexed as well.
+	mustExec(t, tbl, "insert 5 alina alina@example.com")
+	if err := tbl.Close(); err != nil {
+		t.Fatalf("close, expected nil got %v", err)
+	}
+
+	tbl, err := DBOpen(filename)
+	if err != nil {
+		t.Fatalf("open, expected nil got %v", err)
+	}
+	defer tbl.Close()
+
+	if got := mustExec(t, tbl, "select where username match 'zed'"); got != "" {
+		t.Errorf("select, expected no rows got `%s`", got)
+	}
+	if tbl.Pager.reads != 0 {
+		t.Errorf("page reads, expected 0 got %d", tbl.Pager.reads)
+	}
+
+	expected := "(0, alice, alice@example.com)\n" +
+		"(2, Ali-Baba, ali@example.com)\n" +
+		"(5, alina, alina@example.com)\n"
+	if got := mustExec(t, tbl, "select where username match 'ALI'"); got != expected {
+		t.Errorf("select, expected \n`%s`\ngot \n`%s`", expected, got)
+	}
+	if tbl.Pager.reads != 1 {
+		t.Errorf("page reads, expected 1 got %d", tbl.Pager.reads)
+	}
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// QueryRequest is the body of a POST to /query.
+type QueryRequest struct {
+	SQL string `json:"sql"`
+	// PageSize is the max number of rows to return, zero means all rows.
+	PageSize int `json:"pageSize,omitempty"`
+	// Cursor is the token returned by a previous page.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// QueryResponse is the response to a POST to /query.
+type QueryResponse struct {
+	Rows    []JSONRow `json:"rows"`
+	Cursor  string    `json:"cursor,omitempty"`
+	HasMore bool      `json:"hasMore"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// JSONRow is the json representation of a Row. Columns that were not
+// selected are nil.
+type JSONRow struct {
+	ID       *uint32 `json:"id,omitempty"`
+	Username *string `json:"username,omitempty"`
+	Email    *string `json:"email,omitempty"`
+}
+
+func newJSONRow(row *Row, columns []string) JSONRow {
+	if len(columns) == 0 {
+		columns = ColumnNames
+	}
+	var jrow JSONRow
+	for _, column := range columns {
+		switch column {
+		case "id":
+			id := row.ID - 1
+			jrow.ID = &id
+		case "username":
+			username := row.username()
+			jrow.Username = &username
+		case "email":
+			email := row.email()
+			jrow.Email = &email
+		}
+	}
+	return jrow
+}
+
+func encodeCursor(rowNum uint32) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], rowNum)
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+func decodeCursor(cursor string) (uint32, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(b) != 4 {
+		return 0, errors.New("invalid cursor")
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+type handler struct {
+	mu    sync.Mutex
+	table *Table
+}
+
+// NewHandler returns an http.Handler that serves queries against the
+// table on /query.
+func NewHandler(tbl *Table) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/query", &handler{table: tbl})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, resp QueryResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, QueryResponse{Error: "method not allowed"})
+		return
+	}
+	var req QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, QueryResponse{Error: "invalid request body"})
+		return
+	}
+	if req.PageSize < 0 {
+		writeJSON(w, http.StatusBadRequest, QueryResponse{Error: "pageSize must not be negative"})
+		return
+	}
+	statement, result := prepareStatement(req.SQL)
+	if result != PrepareSuccess {
+		writeJSON(w, http.StatusBadRequest, QueryResponse{Error: "could not parse statement"})
+		return
+	}
+	if statement.Type != StatementSelect {
+		writeJSON(w, http.StatusBadRequest, QueryResponse{Error: "only select statements are supported"})
+		return
+	}
+	if statement.From != "" || statement.UnionStmt != nil {
+		writeJSON(w, http.StatusBadRequest, QueryResponse{Error: "from and union are not supported"})
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var start uint32
+	if req.Cursor != "" {
+		last, err := decodeCursor(req.Cursor)
+		if err == nil && last >= h.table.NumRows {
+			// also keeps last + 1 from wrapping around to the first row
+			err = errors.New("invalid cursor")
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, QueryResponse{Error: err.Error()})
+			return
+		}
+		start = last + 1
+	}
+
+	resp := QueryResponse{Rows: []JSONRow{}}
+	var lastRow uint32
+	err := h.table.selectRows(statement, start, func(rowNum uint32, row *Row) bool {
+		if req.PageSize > 0 && len(resp.Rows) == req.PageSize {
+			resp.HasMore = true
+			return false
+		}
+		resp.Rows = append(resp.Rows, newJSONRow(row, statement.Columns))
+		lastRow = rowNum
+		return true
+	})
+	if err == ErrNoFTSIndex {
+		writeJSON(w, http.StatusBadRequest, QueryResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, QueryResponse{Error: err.Error()})
+		return
+	}
+	if resp.HasMore {
+		resp.Cursor = encodeCursor(lastRow)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
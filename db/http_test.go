@@ -0,0 +1,138 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandler_Pagination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbtest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir) // cleanup
+
+	tbl, err := DBOpen(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("open, expected nil got %v", err)
+	}
+	defer tbl.Close()
+
+	const numRows = 25
+	for i := 0; i < numRows; i++ {
+		statement, result := prepareStatement(fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+		if result != PrepareSuccess {
+			t.Fatalf("prepare, expected success got %v", result)
+		}
+		if result := executeStatement(ioutil.Discard, statement, tbl); result != ExecuteSuccess {
+			t.Fatalf("execute, expected success got %v", result)
+		}
+	}
+
+	srv := httptest.NewServer(NewHandler(tbl))
+	defer srv.Close()
+
+	var (
+		rows     []JSONRow
+		requests int
+		cursor   string
+	)
+	for {
+		body, _ := json.Marshal(QueryRequest{SQL: "select", PageSize: 10, Cursor: cursor})
+		res, err := http.Post(srv.URL+"/query", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("post, expected nil got %v", err)
+		}
+		var resp QueryResponse
+		err = json.NewDecoder(res.Body).Decode(&resp)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("decode, expected nil got %v", err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status, expected %d got %d: %v", http.StatusOK, res.StatusCode, resp.Error)
+		}
+		requests++
+		rows = append(rows, resp.Rows...)
+		if !resp.HasMore {
+			break
+		}
+		if resp.Cursor == "" {
+			t.Fatalf("cursor, expected a cursor when hasMore is true")
+		}
+		cursor = resp.Cursor
+	}
+
+	if requests != 3 {
+		t.Errorf("requests, expected 3 got %d", requests)
+	}
+	if len(rows) != numRows {
+		t.Fatalf("rows, expected %d got %d", numRows, len(rows))
+	}
+	for i, row := range rows {
+		if row.ID == nil || row.Username == nil || row.Email == nil {
+			t.Errorf("row %d, expected all columns got %+v", i, row)
+			continue
+		}
+		if *row.ID != uint32(i) || *row.Username != fmt.Sprintf("user%d", i) {
+			t.Errorf("row %d, expected (%[1]d, user%[1]d) got (%d, %s)", i, *row.ID, *row.Username)
+		}
+	}
+}
+
+func TestHandler_Columns(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+	mustExec(t, tbl, "insert 0 user0 person0@example.com")
+
+	srv := httptest.NewServer(NewHandler(tbl))
+	defer srv.Close()
+
+	body, _ := json.Marshal(QueryRequest{SQL: "select id, username"})
+	res, err := http.Post(srv.URL+"/query", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("post, expected nil got %v", err)
+	}
+	defer res.Body.Close()
+	var resp struct {
+		Rows []map[string]interface{} `json:"rows"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode, expected nil got %v", err)
+	}
+	if len(resp.Rows) != 1 {
+		t.Fatalf("rows, expected 1 got %d", len(resp.Rows))
+	}
+	row := resp.Rows[0]
+	if len(row) != 2 || row["id"] != float64(0) || row["username"] != "user0" {
+		t.Errorf("row, expected {id:0 username:user0} got %v", row)
+	}
+}
+
+func TestHandler_InvalidCursor(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+	mustExec(t, tbl, "insert 0 user0 person0@example.com")
+
+	srv := httptest.NewServer(NewHandler(tbl))
+	defer srv.Close()
+
+	for _, cursor := range []string{encodeCursor(0xFFFFFFFF), encodeCursor(1), "not a cursor"} {
+		body, _ := json.Marshal(QueryRequest{SQL: "select", PageSize: 10, Cursor: cursor})
+		res, err := http.Post(srv.URL+"/query", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("post, expected nil got %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("cursor %s status, expected %d got %d", cursor, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package db
+
+import (
+	"errors"
+	"os"
+)
+
+// errLocked is returned by tryLockFile if another process holds the lock.
+var errLocked = errors.New("database file is locked")
+
+// lockFile is a noop, file locking is not supported on this platform.
+func lockFile(file *os.File) error { return nil }
+
+// tryLockFile is a noop, file locking is not supported on this platform.
+func tryLockFile(file *os.File, exclusive bool) error { return nil }
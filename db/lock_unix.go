@@ -0,0 +1,27 @@
+//go:build unix
+
+package db
+
+import (
+	"os"
+	"syscall"
+)
+
+// errLocked is returned by tryLockFile if another process holds the lock.
+var errLocked error = syscall.EWOULDBLOCK
+
+// lockFile takes an exclusive lock on the file, blocking until it is
+// available. The lock is released when the file is closed.
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+// tryLockFile takes an exclusive, or if not exclusive a shared, lock on
+// the file, returning errLocked if the lock is not available.
+func tryLockFile(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+}
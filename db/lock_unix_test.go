@@ -0,0 +1,60 @@
+//go:build unix
+
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewPagerWithTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbtest")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir) // cleanup
+	filename := filepath.Join(dir, "test.db")
+
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	firstErr := make(chan error, 1)
+	go func() {
+		pager, err := NewPager(filename)
+		if err != nil {
+			firstErr <- err
+			close(locked)
+			return
+		}
+		close(locked)
+		<-release
+		firstErr <- pager.Close()
+	}()
+
+	<-locked
+	secondErr := make(chan error, 1)
+	go func() {
+		pager, err := NewPagerWithTimeout(filename, 100*time.Millisecond)
+		if err == nil {
+			pager.Close()
+		}
+		secondErr <- err
+	}()
+
+	if err := <-secondErr; err != ErrLockTimeout {
+		t.Errorf("second pager, expected %v got %v", ErrLockTimeout, err)
+	}
+	close(release)
+	if err := <-firstErr; err != nil {
+		t.Fatalf("first pager, expected nil got %v", err)
+	}
+
+	// now that the lock has been released it should be available.
+	pager, err := NewPagerWithTimeout(filename, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("pager after release, expected nil got %v", err)
+	}
+	pager.Close()
+}
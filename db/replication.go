@@ -0,0 +1,133 @@
+package db
+
+import (
+	"errors"
+	"sync"
+)
+
+// DefaultReplicationSlotSize is the number of changes a replication slot
+// buffers when Table.ReplicationSlotSize is not set.
+const DefaultReplicationSlotSize = 1024
+
+var (
+	ErrReplicationSlotExists = errors.New("replication slot already exists")
+	ErrEmptySlotName         = errors.New("replication slot name must not be empty")
+)
+
+type ChangeType uint
+
+const (
+	// ChangeInsert is a row that was added to the table.
+	ChangeInsert ChangeType = iota
+	// ChangeDelete is a row that was removed from the table, for
+	// example by a rollback.
+	ChangeDelete
+	// ChangeMove is a row that was moved from OldRowNumber to
+	// RowNumber by Compact.
+	ChangeMove
+)
+
+// ChangeEvent is a change that was made to the table.
+type ChangeEvent struct {
+	Type      ChangeType
+	RowNumber uint32
+	// OldRowNumber is only used by ChangeMove.
+	OldRowNumber uint32
+	Row          Row
+}
+
+// ReplicationSlot buffers the changes made to a table until they are
+// drained by a consumer. Once the buffer is full the oldest changes are
+// overwritten.
+type ReplicationSlot struct {
+	Name  string
+	table *Table
+
+	mu     sync.Mutex
+	events []ChangeEvent
+	// start is the index of the oldest event in events
+	start int
+	count int
+}
+
+func (slot *ReplicationSlot) push(event ChangeEvent) {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	end := (slot.start + slot.count) % len(slot.events)
+	slot.events[end] = event
+	if slot.count == len(slot.events) {
+		// buffer is full, drop the oldest event
+		slot.start = (slot.start + 1) % len(slot.events)
+		return
+	}
+	slot.count++
+}
+
+// Changes drains the buffered changes, oldest first.
+func (slot *ReplicationSlot) Changes() []ChangeEvent {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	changes := make([]ChangeEvent, slot.count)
+	for i := range changes {
+		changes[i] = slot.events[(slot.start+i)%len(slot.events)]
+	}
+	slot.start, slot.count = 0, 0
+	return changes
+}
+
+// Drop unregisters the slot from its table, no further changes will be
+// buffered. It is safe to call from a different goroutine than the one
+// changing the table.
+func (slot *ReplicationSlot) Drop() {
+	if slot == nil {
+		return
+	}
+	slot.mu.Lock()
+	tbl := slot.table
+	slot.table = nil
+	slot.mu.Unlock()
+	if tbl == nil {
+		return
+	}
+	tbl.slotsMu.Lock()
+	defer tbl.slotsMu.Unlock()
+	if tbl.slots[slot.Name] == slot {
+		delete(tbl.slots, slot.Name)
+	}
+}
+
+// CreateReplicationSlot registers a new slot that will receive every change
+// made to the table from now on.
+func (tbl *Table) CreateReplicationSlot(name string) (*ReplicationSlot, error) {
+	if name == "" {
+		return nil, ErrEmptySlotName
+	}
+	tbl.slotsMu.Lock()
+	defer tbl.slotsMu.Unlock()
+	if _, ok := tbl.slots[name]; ok {
+		return nil, ErrReplicationSlotExists
+	}
+	size := tbl.ReplicationSlotSize
+	if size <= 0 {
+		size = DefaultReplicationSlotSize
+	}
+	slot := &ReplicationSlot{
+		Name:   name,
+		table:  tbl,
+		events: make([]ChangeEvent, size),
+	}
+	if tbl.slots == nil {
+		tbl.slots = make(map[string]*ReplicationSlot)
+	}
+	tbl.slots[name] = slot
+	return slot, nil
+}
+
+// publish sends the event to every registered replication slot.
+func (tbl *Table) publish(event ChangeEvent) {
+	tbl.slotsMu.Lock()
+	defer tbl.slotsMu.Unlock()
+	for _, slot := range tbl.slots {
+		slot.push(event)
+	}
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestTable_ReplicationSlots(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+
+	slot1, err := tbl.CreateReplicationSlot("one")
+	if err != nil {
+		t.Fatalf("create slot, expected nil got %v", err)
+	}
+	slot2, err := tbl.CreateReplicationSlot("two")
+	if err != nil {
+		t.Fatalf("create slot, expected nil got %v", err)
+	}
+	if _, err := tbl.CreateReplicationSlot("one"); err != ErrReplicationSlotExists {
+		t.Errorf("create duplicate slot, expected %v got %v", ErrReplicationSlotExists, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		mustExec(t, tbl, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+
+	for _, slot := range []*ReplicationSlot{slot1, slot2} {
+		changes := slot.Changes()
+		if len(changes) != 3 {
+			t.Errorf("slot %s changes, expected 3 got %d", slot.Name, len(changes))
+			continue
+		}
+		for i, change := range changes {
+			if change.Type != ChangeInsert || change.RowNumber != uint32(i) || change.Row.username() != fmt.Sprintf("user%d", i) {
+				t.Errorf("slot %s change %d, expected insert of user%[2]d at %[2]d got %v", slot.Name, i, change)
+			}
+		}
+		if changes := slot.Changes(); len(changes) != 0 {
+			t.Errorf("slot %s changes after drain, expected 0 got %d", slot.Name, len(changes))
+		}
+	}
+
+	slot1.Drop()
+	mustExec(t, tbl, "insert 3 user3 person3@example.com")
+	if changes := slot1.Changes(); len(changes) != 0 {
+		t.Errorf("dropped slot changes, expected 0 got %d", len(changes))
+	}
+	if changes := slot2.Changes(); len(changes) != 1 {
+		t.Errorf("slot changes, expected 1 got %d", len(changes))
+	}
+}
+
+func TestReplicationSlot_Overflow(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+	tbl.ReplicationSlotSize = 2
+
+	slot, err := tbl.CreateReplicationSlot("small")
+	if err != nil {
+		t.Fatalf("create slot, expected nil got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		mustExec(t, tbl, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	changes := slot.Changes()
+	if len(changes) != 2 {
+		t.Fatalf("changes, expected 2 got %d", len(changes))
+	}
+	if changes[0].RowNumber != 1 || changes[1].RowNumber != 2 {
+		t.Errorf("changes, expected rows 1 and 2 got %d and %d", changes[0].RowNumber, changes[1].RowNumber)
+	}
+}
+
+func TestReplicationSlot_DropWhileInserting(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	slots := make(chan *ReplicationSlot, 10)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for slot := range slots {
+			slot.Changes()
+			slot.Drop()
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		slot, err := tbl.CreateReplicationSlot(fmt.Sprintf("slot%d", i))
+		if err != nil {
+			t.Fatalf("create slot, expected nil got %v", err)
+		}
+		slots <- slot
+		mustExec(t, tbl, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	close(slots)
+	wg.Wait()
+	if len(tbl.slots) != 0 {
+		t.Errorf("slots, expected 0 got %d", len(tbl.slots))
+	}
+}
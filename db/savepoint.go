@@ -0,0 +1,89 @@
+package db
+
+import (
+	"fmt"
+	"io"
+)
+
+// Savepoint is a snapshot of the table that changes can be rolled back to.
+type Savepoint struct {
+	Name    string
+	NumRows uint32
+}
+
+// findSavepoint returns the index of the most recent savepoint with
+// the given name, or -1 if there is none.
+func (tbl *Table) findSavepoint(name string) int {
+	for i := len(tbl.savepoints) - 1; i >= 0; i-- {
+		if tbl.savepoints[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (tbl *Table) executeSavepoint(out io.Writer, statement *Statement) ExecuteResult {
+	tbl.savepoints = append(tbl.savepoints, Savepoint{
+		Name:    statement.Name,
+		NumRows: tbl.NumRows,
+	})
+	return ExecuteSuccess
+}
+
+// executeRollbackTo removes the rows inserted since the savepoint was
+// taken, publishing a ChangeDelete for each of them, and truncates the
+// backing file to the pages still in use. The savepoint is kept, but any
+// savepoints after it are released.
+func (tbl *Table) executeRollbackTo(out io.Writer, statement *Statement) ExecuteResult {
+	i := tbl.findSavepoint(statement.Name)
+	if i == -1 {
+		return ExecuteNoSuchSavepoint
+	}
+	sp := tbl.savepoints[i]
+	tbl.savepoints = tbl.savepoints[:i+1]
+	if sp.NumRows >= tbl.NumRows {
+		return ExecuteSuccess
+	}
+	for rowNum := sp.NumRows; rowNum < tbl.NumRows; rowNum++ {
+		slot, err := tbl.RowSlot(rowNum)
+		if err != nil {
+			fmt.Fprintf(out, "failed to get row, %v", err)
+			return ExecuteFailedFile
+		}
+		row := *DeseralizeRow(slot)
+		*slot = [RowSize]byte{}
+		if row.ID != 0 {
+			tbl.publish(ChangeEvent{
+				Type:      ChangeDelete,
+				RowNumber: rowNum,
+				Row:       row,
+			})
+		}
+	}
+	tbl.NumRows = sp.NumRows
+	// drop the pages that are now empty, otherwise the rows on disk
+	// would be counted from the wrong page when the table is reopened.
+	numPages := (tbl.NumRows + RowsPerPage - 1) / RowsPerPage
+	if err := tbl.Pager.truncate(int(numPages)); err != nil {
+		fmt.Fprintf(out, "failed to truncate table, %v", err)
+		return ExecuteFailedFile
+	}
+	if tbl.fts != nil {
+		// the index may reference rows that no longer exist
+		if err := tbl.buildFTSIndex(tbl.fts.Column); err != nil {
+			fmt.Fprintf(out, "failed to rebuild index, %v", err)
+			return ExecuteFailedFile
+		}
+	}
+	return ExecuteSuccess
+}
+
+// executeRelease discards the savepoint and every savepoint after it.
+func (tbl *Table) executeRelease(out io.Writer, statement *Statement) ExecuteResult {
+	i := tbl.findSavepoint(statement.Name)
+	if i == -1 {
+		return ExecuteNoSuchSavepoint
+	}
+	tbl.savepoints = tbl.savepoints[:i]
+	return ExecuteSuccess
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestTable_Savepoint(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+
+	mustExec(t, tbl, "insert 1 user1 person1@example.com")
+	mustExec(t, tbl, "insert 2 user2 person2@example.com")
+	mustExec(t, tbl, "savepoint sp1")
+	mustExec(t, tbl, "insert 3 user3 person3@example.com")
+	mustExec(t, tbl, "savepoint sp2")
+	mustExec(t, tbl, "insert 4 user4 person4@example.com")
+	if tbl.Count() != 4 {
+		t.Errorf("count, expected 4 got %d", tbl.Count())
+	}
+
+	mustExec(t, tbl, "rollback to sp1")
+	if tbl.Count() != 2 {
+		t.Errorf("count, expected 2 got %d", tbl.Count())
+	}
+	expected := "(1, user1, person1@example.com)\n(2, user2, person2@example.com)\n"
+	if got := mustExec(t, tbl, "select"); got != expected {
+		t.Errorf("select, expected \n`%s`\ngot \n`%s`", expected, got)
+	}
+
+	// sp2 was taken after sp1, so it is gone; sp1 is kept.
+	statement, _ := prepareStatement("rollback to sp2")
+	if result := executeStatement(ioutil.Discard, statement, tbl); result != ExecuteNoSuchSavepoint {
+		t.Errorf("rollback to sp2, expected %v got %v", ExecuteNoSuchSavepoint, result)
+	}
+	mustExec(t, tbl, "insert 5 user5 person5@example.com")
+	mustExec(t, tbl, "rollback to savepoint sp1")
+	if tbl.Count() != 2 {
+		t.Errorf("count, expected 2 got %d", tbl.Count())
+	}
+
+	mustExec(t, tbl, "release sp1")
+	statement, _ = prepareStatement("rollback to sp1")
+	if result := executeStatement(ioutil.Discard, statement, tbl); result != ExecuteNoSuchSavepoint {
+		t.Errorf("rollback to released sp1, expected %v got %v", ExecuteNoSuchSavepoint, result)
+	}
+}
+
+func TestTable_SavepointReplication(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+
+	slot, err := tbl.CreateReplicationSlot("consumer")
+	if err != nil {
+		t.Fatalf("create slot, expected nil got %v", err)
+	}
+	mustExec(t, tbl, "insert 1 user1 person1@example.com")
+	mustExec(t, tbl, "insert 2 user2 person2@example.com")
+	mustExec(t, tbl, "savepoint sp1")
+	mustExec(t, tbl, "insert 3 user3 person3@example.com")
+	mustExec(t, tbl, "insert 4 user4 person4@example.com")
+	mustExec(t, tbl, "rollback to sp1")
+
+	// apply the changes to a copy of the table, as a consumer would.
+	replica := make(map[uint32]Row)
+	for _, change := range slot.Changes() {
+		switch change.Type {
+		case ChangeInsert:
+			replica[change.RowNumber] = change.Row
+		case ChangeDelete:
+			if _, ok := replica[change.RowNumber]; !ok {
+				t.Errorf("delete of row %d, expected row to exist", change.RowNumber)
+			}
+			delete(replica, change.RowNumber)
+		}
+	}
+	if len(replica) != tbl.Count() {
+		t.Errorf("replica rows, expected %d got %d", tbl.Count(), len(replica))
+	}
+	for rowNum := uint32(0); rowNum < tbl.NumRows; rowNum++ {
+		if _, ok := replica[rowNum]; !ok {
+			t.Errorf("replica, expected row %d", rowNum)
+		}
+	}
+}
+
+func TestTable_SavepointCompact(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+
+	for i := 0; i < 4; i++ {
+		mustExec(t, tbl, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	mustExec(t, tbl, "savepoint sp1")
+	for i := 4; i < 8; i++ {
+		mustExec(t, tbl, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	// empty out rows on both sides of the savepoint, so rows from each
+	// side are moved.
+	for _, rowNum := range []uint32{1, 5} {
+		slot, err := tbl.RowSlot(rowNum)
+		if err != nil {
+			t.Fatalf("row slot, expected nil got %v", err)
+		}
+		*slot = [RowSize]byte{}
+	}
+	if err := tbl.Compact(); err != nil {
+		t.Fatalf("compact, expected nil got %v", err)
+	}
+
+	mustExec(t, tbl, "rollback to sp1")
+	expected := "(0, user0, person0@example.com)\n" +
+		"(2, user2, person2@example.com)\n" +
+		"(3, user3, person3@example.com)\n"
+	if got := mustExec(t, tbl, "select"); got != expected {
+		t.Errorf("select, expected \n`%s`\ngot \n`%s`", expected, got)
+	}
+}
+
+func TestTable_SavepointReopen(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+	filename := tbl.Pager.backing.Name()
+
+	for i := 0; i < 10; i++ {
+		mustExec(t, tbl, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	mustExec(t, tbl, "savepoint sp1")
+	for i := 10; i < 20; i++ {
+		mustExec(t, tbl, fmt.Sprintf("insert %d user%d person%d@example.com", i, i, i))
+	}
+	mustExec(t, tbl, "rollback to sp1")
+	if err := tbl.Close(); err != nil {
+		t.Fatalf("close, expected nil got %v", err)
+	}
+
+	tbl, err := DBOpen(filename)
+	if err != nil {
+		t.Fatalf("open, expected nil got %v", err)
+	}
+	defer tbl.Close()
+	if tbl.NumRows != 10 {
+		t.Errorf("num rows, expected 10 got %d", tbl.NumRows)
+	}
+	if tbl.Pager.Length != PageSize {
+		t.Errorf("length, expected %d got %d", PageSize, tbl.Pager.Length)
+	}
+}
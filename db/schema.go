@@ -0,0 +1,50 @@
+package db
+
+type ColumnType uint
+
+const (
+	ColumnInteger ColumnType = iota
+	ColumnVarchar
+)
+
+// ColumnDef describes a column of the table.
+type ColumnDef struct {
+	Name string
+	Type ColumnType
+	// NotNull rejects rows where the column has its zero value.
+	NotNull bool
+}
+
+// DefaultSchema returns the column definitions of a Row, without any
+// constraints.
+func DefaultSchema() []ColumnDef {
+	return []ColumnDef{
+		{Name: "id", Type: ColumnInteger},
+		{Name: "username", Type: ColumnVarchar},
+		{Name: "email", Type: ColumnVarchar},
+	}
+}
+
+// isNull reports whether the row has the zero value for the column.
+func (r Row) isNull(column ColumnDef) bool {
+	switch column.Type {
+	case ColumnInteger:
+		// ids are stored one more than the value given
+		return r.ID <= 1
+	case ColumnVarchar:
+		return r.varcharValue(column.Name) == ""
+	default:
+		return false
+	}
+}
+
+// checkNotNull returns the name of the first NOT NULL column that is
+// null in the row, or "" if the row satisfies the schema.
+func (tbl *Table) checkNotNull(row *Row) string {
+	for _, column := range tbl.Schema {
+		if column.NotNull && row.isNull(column) {
+			return column.Name
+		}
+	}
+	return ""
+}
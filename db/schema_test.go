@@ -0,0 +1,38 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTable_NotNull(t *testing.T) {
+	tbl, cleanup := openTestTable(t)
+	defer cleanup()
+	for i := range tbl.Schema {
+		if tbl.Schema[i].Name == "email" {
+			tbl.Schema[i].NotNull = true
+		}
+	}
+
+	row := Row{ID: 2}
+	copy(row.Username[:], "user1")
+	statement := &Statement{Type: StatementInsert, InsertRow: &row}
+	buff := new(bytes.Buffer)
+	if result := executeStatement(buff, statement, tbl); result != ExecuteNotNullViolation {
+		t.Errorf("insert, expected %v got %v", ExecuteNotNullViolation, result)
+	}
+	if buff.Len() != 0 {
+		t.Errorf("output, expected nothing got `%s`", buff.String())
+	}
+	if column := tbl.checkNotNull(&row); column != "email" {
+		t.Errorf("not null column, expected email got '%s'", column)
+	}
+	if tbl.NumRows != 0 {
+		t.Errorf("num rows, expected 0 got %d", tbl.NumRows)
+	}
+
+	mustExec(t, tbl, "insert 1 user1 person1@example.com")
+	if tbl.NumRows != 1 {
+		t.Errorf("num rows, expected 1 got %d", tbl.NumRows)
+	}
+}